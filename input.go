@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// Sender delivers a raw remote-control command to the DPT-S1 (or to
+// whatever alternative back-channel implements it), decoupling the
+// transport from the ebiten event loop.
+type Sender interface {
+	Send(cmd []byte) error
+	Close() error
+}
+
+// tcpSender is the default Sender: it opens a second TCP connection to the
+// device (separate from the one Refresh uses to pull frames) and writes
+// commands to it, reconnecting lazily if it drops.
+//
+// The <command> XML written by touchCommand/keyCommand is *not* a
+// documented or confirmed DPT-S1 protocol -- it's a guess modeled on the
+// device's own outbound frame stream, and has not been verified to do
+// anything on real hardware. Treat tcpSender as a placeholder wiring for
+// the Sender seam until someone confirms the real remote-control format;
+// don't assume -readonly's absence means input actually reaches the device.
+type tcpSender struct {
+	addr string
+	conn net.Conn
+}
+
+func newTCPSender(addr string) *tcpSender {
+	return &tcpSender{addr: addr}
+}
+
+func (s *tcpSender) Send(cmd []byte) error {
+	if s.conn == nil {
+		conn, err := net.Dial("tcp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write(cmd); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *tcpSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// keyCommands maps the subset of keys we understand to the command name
+// placed in keyCommand's speculative payload.
+var keyCommands = map[ebiten.Key]string{
+	ebiten.KeyArrowLeft:  "prev",
+	ebiten.KeyArrowRight: "next",
+	ebiten.KeyPageUp:     "prev",
+	ebiten.KeyPageDown:   "next",
+	ebiten.KeyEnter:      "select",
+}
+
+// touchCommand and keyCommand build the speculative XML payload described
+// on tcpSender above; they are not a confirmed DPT-S1 protocol.
+func touchCommand(x, y int) []byte {
+	return []byte(fmt.Sprintf("<command><touch x=\"%d\" y=\"%d\"/></command>\n", x, y))
+}
+
+func keyCommand(name string) []byte {
+	return []byte(fmt.Sprintf("<command><key name=\"%s\"/></command>\n", name))
+}
+
+// screenToDevice maps an ebiten cursor position (in Layout's coordinate
+// space) back to the device's native 1200x1600 pixel space, undoing the
+// rotation applied by ROTATE when the device is in landscape mode.
+func (d *device) screenToDevice(x, y int) (int, int) {
+	if !d.landscape.Load() {
+		return x, y
+	}
+	return 1200 - y, x
+}
+
+// handleInput translates mouse clicks and key presses into DPT-S1 remote
+// commands, sent over d.sender. It is a no-op if the device has no sender
+// (-readonly was set, the device is fed by -connect or -replay, or this
+// device isn't the primary one).
+func (d *device) handleInput() {
+	if d.sender == nil {
+		return
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		x, y := ebiten.CursorPosition()
+		dx, dy := d.screenToDevice(x, y)
+		if err := d.sender.Send(touchCommand(dx, dy)); err != nil {
+			fmt.Fprintln(os.Stderr, "send touch command:", err)
+		}
+	}
+	for _, key := range inpututil.AppendJustPressedKeys(nil) {
+		name, ok := keyCommands[key]
+		if !ok {
+			continue
+		}
+		if err := d.sender.Send(keyCommand(name)); err != nil {
+			fmt.Fprintln(os.Stderr, "send key command:", err)
+		}
+	}
+}
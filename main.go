@@ -11,9 +11,12 @@ import (
 	"image/jpeg"
 	"github.com/hajimehoshi/ebiten/v2"
 	"bufio"
+	"bytes"
 	"flag"
+	"hash/fnv"
 	"runtime/pprof"
 	"math"
+	"strings"
 )
 
 type device struct {
@@ -25,6 +28,46 @@ type device struct {
 	rgbabuffer *image.RGBA
 	lastDraw *image.YCbCr
 	buffer *bufio.Reader
+
+	// peerAddr, when set (via -connect), makes Refresh consume frames from
+	// another instance's -serve endpoint instead of dialing the DPT-S1
+	// directly. peerConn holds the long-lived connection to that peer.
+	peerAddr string
+	peerConn net.Conn
+
+	// autoResize controls whether orientation changes resize the window.
+	// It is disabled when the device is one of several owned by a Viewer,
+	// since the window is then sized to fit all of them.
+	autoResize bool
+
+	// recorder, when set (via -record), appends every frame fetched from
+	// the DPT-S1 to a file. replayer, when set (via -replay), makes
+	// Refresh read frames back out of such a file instead of dialing the
+	// device; replayFixedInterval overrides the recorded cadence with a
+	// fixed one when non-zero. replayMinWait paces the very first replayed
+	// frame, which has no preceding timestamp to compute a delta from.
+	recorder *frameRecorder
+	replayer *frameReplayer
+	replayFixedInterval time.Duration
+	replayMinWait time.Duration
+
+	// lastFrameHash/hasFrameHash let Refresh tell whether the JPEG payload
+	// changed since last time, without fully decoding it. minInterval,
+	// maxInterval and currentInterval implement the adaptive backoff: the
+	// polling interval doubles (capped at maxInterval) on unchanged frames
+	// and snaps back to minInterval as soon as something changes.
+	lastFrameHash   uint64
+	hasFrameHash    bool
+	minInterval     time.Duration
+	maxInterval     time.Duration
+	currentInterval time.Duration
+
+	// sender, when set, turns Update into an actual remote control: mouse
+	// clicks and key presses are translated into DPT-S1 commands and sent
+	// over it. Left nil by -readonly, and for any device not dialed directly
+	// (-connect, -replay, or the second -compare device), to keep the viewer
+	// a passive mirror.
+	sender Sender
 }
 
 var ENDTAG = []byte("</command>\n")
@@ -42,16 +85,28 @@ func (d *device) Update() error {
 	// we do not want that happen in the critical path. Instead the screen
 	// refreshing logic happens in the Refresh function which we call in a
 	// separate goroutine.
+	d.handleInput()
 	return nil
 }
 
-func (d *device) Refresh() error {
+// Refresh fetches the next frame and reports whether it changed from the
+// previous one, so callers can back off polling when the screen is static.
+// For frames fetched from the DPT-S1 this compares a hash of the raw JPEG
+// bytes; frames from -connect or -replay are always reported as changed.
+func (d *device) Refresh() (changed bool, err error) {
+	if d.peerAddr != "" {
+		return d.refreshFromPeer()
+	}
+	if d.replayer != nil {
+		return d.refreshFromReplay()
+	}
+
 	// It is silly that we have to start new TCP connections every time. But it
 	// seems like the behavior of Sony's client as well, so there is little we
 	// can do.
 	conn, err := net.Dial("tcp", d.addr)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer conn.Close()
 	if d.buffer == nil {
@@ -65,7 +120,7 @@ func (d *device) Refresh() error {
 	for demidx < len(ENDTAG) {
 		b, err := d.buffer.ReadByte()
 		if err != nil {
-			return err
+			return false, err
 		}
 		if b == ENDTAG[demidx] {
 			demidx += 1
@@ -80,58 +135,271 @@ func (d *device) Refresh() error {
 			}
 		}
 	}
-	img, err := jpeg.Decode(d.buffer)
+	orientation := byte('P')
+	if poridx != len(PORTRAIT) {
+		orientation = 'L'
+	}
+
+	raw, err := readJPEGFrame(d.buffer)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if d.recorder != nil {
+		if err := d.recorder.Record(orientation, raw); err != nil {
+			fmt.Fprintln(os.Stderr, "record frame:", err)
+		}
 	}
 
-	if poridx != len(PORTRAIT) {
+	h := fnv.New64a()
+	h.Write(raw)
+	sum := h.Sum64()
+	changed = !d.hasFrameHash || sum != d.lastFrameHash
+	d.hasFrameHash = true
+	d.lastFrameHash = sum
+	if !changed {
+		// the frame has already been downloaded, but decoding it and
+		// copying it into the framebuffer can still be skipped when the
+		// DPT-S1 is showing the same page as last time.
+		return false, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return false, err
+	}
+
+	if orientation == 'L' {
 		// landscape mode; we need to rotate the image
 		// resize window if not already in landscape mode
-		if d.landscape.CompareAndSwap(false, true) {
-			ebiten.SetWindowSize(800, 600)
+		if d.landscape.CompareAndSwap(false, true) && d.autoResize {
+			resizeWindowLandscape()
 		}
 	} else {
 		// portrait mode, resize window if needed
-		if d.landscape.CompareAndSwap(true, false) {
-			ebiten.SetWindowSize(600, 800)
+		if d.landscape.CompareAndSwap(true, false) && d.autoResize {
+			resizeWindowPortrait()
 		}
 	}
 	d.display.Store(img.(*image.YCbCr))
-	return nil
+	return true, nil
 }
 
-func (d *device) Draw(screen *ebiten.Image){
-	img := d.display.Load()
-	if img != nil {
-		if d.framebuffer == nil {
-			d.framebuffer = ebiten.NewImage(1200, 1600)
-		}
-		// We could have called image/draw.Draw to draw directly on the
-		// framebuffer (ebiten.Image), but apparently that calls draw.Set on
-		// individual pixels and is slow. draw.Draw has specialization for
-		// image.RGBA, so we allocate an image.RGBA (reused) as the
-		// intermediary.
-		// Idea came from https://github.com/hajimehoshi/ebiten/blob/4c520581b89b05c1dd06baaa7c646f095f37980a/imagetobytes.go#L78
-		if d.rgbabuffer == nil {
-			d.rgbabuffer = &image.RGBA{
-				Pix: make([]byte, 4*1200*1600),
-				Stride: 4*1200,
-				Rect: image.Rectangle{image.Point{0, 0}, image.Point{1200, 1600}},
+// readJPEGFrame reads a single JFIF-encoded frame from r, stopping right
+// after the top-level end-of-image marker, so the raw bytes can be hashed
+// or recorded without a full jpeg.Decode. Unlike a naive scan for the first
+// 0xFFD9 byte pair, it walks the actual marker structure (length-prefixed
+// segments, entropy-coded scan data) so an embedded EXIF/JFIF thumbnail --
+// which carries its own SOI/EOI -- can't be mistaken for the end of the
+// outer image.
+func readJPEGFrame(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	marker, err := readJPEGMarker(r, &buf)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case marker == jpegEOI:
+			return buf.Bytes(), nil
+		case marker == jpegSOI || (marker >= jpegRST0 && marker <= jpegRST7) || marker == 0x01:
+			// no-payload markers: SOI, restart markers, TEM
+			marker, err = readJPEGMarker(r, &buf)
+		case marker == jpegSOS:
+			if err = readJPEGSegment(r, &buf); err == nil {
+				marker, err = skipJPEGEntropyData(r, &buf)
 			}
+		default:
+			// length-prefixed segment: APPn, DQT, SOF, DHT, COM, etc. --
+			// including any embedded thumbnail, which is skipped whole
+			// rather than scanned byte by byte.
+			if err = readJPEGSegment(r, &buf); err == nil {
+				marker, err = readJPEGMarker(r, &buf)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+const (
+	jpegSOI  = 0xD8
+	jpegEOI  = 0xD9
+	jpegSOS  = 0xDA
+	jpegRST0 = 0xD0
+	jpegRST7 = 0xD7
+)
+
+// readJPEGMarker reads up to and including the next marker code (the byte
+// following a 0xFF, skipping any 0xFF fill bytes), appending everything it
+// consumes to buf, and returns that marker code.
+func readJPEGMarker(r *bufio.Reader, buf *bytes.Buffer) (byte, error) {
+	prev := byte(0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
 		}
-		if d.lastDraw != img {
-			draw.Draw(d.rgbabuffer, image.Rectangle{image.Point{0, 0}, image.Point{1200, 1600}}, img, image.Point{0, 0}, draw.Src)
-			d.framebuffer.WritePixels(d.rgbabuffer.Pix)
-			d.lastDraw = img
+		buf.WriteByte(b)
+		if prev == 0xFF && b != 0xFF {
+			return b, nil
 		}
-		if d.landscape.Load() {
-			screen.DrawImage(d.framebuffer, ROTATE)
+		if b == 0xFF {
+			prev = 0xFF
 		} else {
-			screen.DrawImage(d.framebuffer, nil)
+			prev = 0
+		}
+	}
+}
+
+// readJPEGSegment reads a standard length-prefixed marker segment (the
+// 2-byte big-endian length, itself included in the count, followed by the
+// rest of the payload) and appends it to buf whole.
+func readJPEGSegment(r *bufio.Reader, buf *bytes.Buffer) error {
+	hi, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	lo, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(hi)
+	buf.WriteByte(lo)
+	length := int(hi)<<8 | int(lo)
+	for i := 0; i < length-2; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(b)
+	}
+	return nil
+}
+
+// skipJPEGEntropyData reads the entropy-coded scan data that follows a SOS
+// segment -- where 0xFF00 is a stuffed literal 0xFF and 0xFFD0-0xFFD7 are
+// restart markers, neither of which end the scan -- until it reaches the
+// next real marker, which it returns without consuming further.
+func skipJPEGEntropyData(r *bufio.Reader, buf *bytes.Buffer) (byte, error) {
+	prev := byte(0)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if prev == 0xFF {
+			if b == 0x00 {
+				buf.WriteByte(b)
+				prev = 0
+				continue
+			}
+			if b >= jpegRST0 && b <= jpegRST7 {
+				buf.WriteByte(b)
+				prev = 0
+				continue
+			}
+			if b == 0xFF {
+				buf.WriteByte(b)
+				continue
+			}
+			buf.WriteByte(b)
+			return b, nil
+		}
+		buf.WriteByte(b)
+		if b == 0xFF {
+			prev = 0xFF
+		} else {
+			prev = 0
+		}
+	}
+}
+
+// refreshFromReplay reads the next frame out of a file previously written
+// by -record instead of dialing the DPT-S1, pacing itself at either the
+// recorded intervals or replayFixedInterval if that was set via -i.
+func (d *device) refreshFromReplay() (changed bool, err error) {
+	orientation, jpegBytes, wait, err := d.replayer.Next()
+	if err != nil {
+		return false, err
+	}
+	if d.replayFixedInterval > 0 {
+		time.Sleep(d.replayFixedInterval)
+	} else if wait > 0 {
+		time.Sleep(wait)
+	} else {
+		// first frame of a replay (or a recording with no second frame)
+		// has no preceding timestamp to pace against.
+		time.Sleep(d.replayMinWait)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(jpegBytes))
+	if err != nil {
+		return false, err
+	}
+
+	if orientation == 'L' {
+		if d.landscape.CompareAndSwap(false, true) && d.autoResize {
+			resizeWindowLandscape()
+		}
+	} else {
+		if d.landscape.CompareAndSwap(true, false) && d.autoResize {
+			resizeWindowPortrait()
 		}
 	}
-	return
+	d.display.Store(img.(*image.YCbCr))
+	return true, nil
+}
+
+func resizeWindowLandscape() {
+	ebiten.SetWindowSize(800, 600)
+}
+
+func resizeWindowPortrait() {
+	ebiten.SetWindowSize(600, 800)
+}
+
+// prepareFramebuffer copies the latest frame (if it changed) into
+// d.framebuffer and returns it, or nil if no frame has arrived yet.
+func (d *device) prepareFramebuffer() *ebiten.Image {
+	img := d.display.Load()
+	if img == nil {
+		return nil
+	}
+	if d.framebuffer == nil {
+		d.framebuffer = ebiten.NewImage(1200, 1600)
+	}
+	// We could have called image/draw.Draw to draw directly on the
+	// framebuffer (ebiten.Image), but apparently that calls draw.Set on
+	// individual pixels and is slow. draw.Draw has specialization for
+	// image.RGBA, so we allocate an image.RGBA (reused) as the
+	// intermediary.
+	// Idea came from https://github.com/hajimehoshi/ebiten/blob/4c520581b89b05c1dd06baaa7c646f095f37980a/imagetobytes.go#L78
+	if d.rgbabuffer == nil {
+		d.rgbabuffer = &image.RGBA{
+			Pix: make([]byte, 4*1200*1600),
+			Stride: 4*1200,
+			Rect: image.Rectangle{image.Point{0, 0}, image.Point{1200, 1600}},
+		}
+	}
+	if d.lastDraw != img {
+		draw.Draw(d.rgbabuffer, image.Rectangle{image.Point{0, 0}, image.Point{1200, 1600}}, img, image.Point{0, 0}, draw.Src)
+		d.framebuffer.WritePixels(d.rgbabuffer.Pix)
+		d.lastDraw = img
+	}
+	return d.framebuffer
+}
+
+func (d *device) Draw(screen *ebiten.Image){
+	fb := d.prepareFramebuffer()
+	if fb == nil {
+		return
+	}
+	if d.landscape.Load() {
+		screen.DrawImage(fb, ROTATE)
+	} else {
+		screen.DrawImage(fb, nil)
+	}
 }
 
 func (d *device) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
@@ -144,10 +412,24 @@ func (d *device) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHei
 
 func main() {
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
-	interval := flag.Duration("i", time.Duration(1 * time.Second), "refreshing interval")
-	usePolling := flag.Bool("poll", false, "use polling to discover DPT-S1 (avoids opening UDP listening socket)")
+	interval := flag.Duration("i", time.Duration(1 * time.Second), "refreshing interval (minimum, with adaptive backoff)")
+	maxInterval := flag.Duration("max-i", time.Duration(16 * time.Second), "maximum refreshing interval to back off to when the page isn't changing")
+	discover := flag.String("discover", "multicast,poll", "comma-separated discovery modes to try in order: mdns, multicast, poll")
+	serve := flag.String("serve", "", "if set, re-serve the decoded frames on this address in addition to displaying them locally")
+	connect := flag.String("connect", "", "if set, consume frames from another instance's -serve endpoint instead of dialing the DPT-S1 directly")
+	compare := flag.String("compare", "", "if set, additionally connect to a second DPT-S1 at this address and render both side by side with a diff overlay")
+	record := flag.String("record", "", "if set, append every frame fetched from the DPT-S1 to this file")
+	replay := flag.String("replay", "", "if set, read frames back out of a file written by -record instead of dialing the DPT-S1")
+	readonly := flag.Bool("readonly", false, "disable sending touch/key input back to the DPT-S1")
 	flag.Parse()
 
+	intervalSetByUser := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "i" {
+			intervalSetByUser = true
+		}
+	})
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -162,40 +444,94 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	// locate DPT-S1
-	var err error
-	var addr string
-	if *usePolling {
-		addr, err = getDPTS1AddrPolling()
+	d := &device{
+		display: &atomic.Pointer[image.YCbCr]{},
+		landscape: &atomic.Bool{},
+	}
+
+	if *connect != "" {
+		// consume frames from a peer's -serve endpoint; no need to locate
+		// the DPT-S1 ourselves.
+		d.peerAddr = *connect
+	} else if *replay != "" {
+		replayer, err := newFrameReplayer(*replay)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		d.replayer = replayer
+		d.replayMinWait = *interval
+		if intervalSetByUser {
+			d.replayFixedInterval = *interval
+		}
 	} else {
-		addr, err = getDPTS1Addr()
+		order := strings.Split(*discover, ",")
+		addr, err := discoverDPTS1Addr(order)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("found DPT-S1 at", addr)
+		d.addr = addr
 	}
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+
+	if *record != "" {
+		recorder, err := newFrameRecorder(*record)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		d.recorder = recorder
 	}
-	fmt.Println("found DPT-S1 at", addr)
 
-	d := &device{
-		addr: addr,
-		display: &atomic.Pointer[image.YCbCr]{},
-		landscape: &atomic.Bool{},
+	if !*readonly && d.addr != "" {
+		d.sender = newTCPSender(d.addr)
 	}
-	d.Refresh()	// make sure we have the image before start the UI
-	if d.landscape.Load() {
+
+	devices := []*device{d}
+	if *compare != "" {
+		d2 := &device{
+			addr: *compare,
+			display: &atomic.Pointer[image.YCbCr]{},
+			landscape: &atomic.Bool{},
+		}
+		devices = append(devices, d2)
+	} else {
+		d.autoResize = true
+	}
+
+	var server *frameServer
+	if *serve != "" {
+		var err error
+		server, err = newFrameServer(*serve)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("serving frames on", *serve)
+	}
+
+	v := NewViewer(devices)
+	for _, dev := range devices {
+		dev.Refresh()	// make sure we have the image before start the UI
+	}
+	if server != nil {
+		// seed the server's last-frame cache so a subordinate viewer that
+		// connects before the primary device's page ever changes still
+		// gets something, instead of waiting on a change that may never come.
+		server.Broadcast(d.display.Load(), d.landscape.Load())
+	}
+	if *compare != "" {
+		w, h := v.Layout(0, 0)
+		ebiten.SetWindowSize(w, h)
+	} else if d.landscape.Load() {
 		ebiten.SetWindowSize(800, 600)
 	} else {
 		ebiten.SetWindowSize(600, 800)
 	}
 	ebiten.SetWindowTitle("DPT-S1 Display")
-	go func() {
-		t := time.NewTicker(*interval)
-		for {
-			<-t.C
-			d.Refresh()
-		}
-	}()
-	if err := ebiten.RunGame(d); err != nil {
+	v.StartRefreshLoop(*interval, *maxInterval, server)
+	if err := ebiten.RunGame(v); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
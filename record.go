@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Each recorded frame is stored as a fixed 13-byte header (8-byte
+// big-endian Unix nanosecond timestamp, 1-byte orientation, 4-byte
+// big-endian length) followed by that many bytes of raw JPEG payload.
+const frameHeaderSize = 8 + 1 + 4
+
+// frameRecorder appends every frame seen by a device to a simple framed
+// container, so the viewer can be demoed or a bug report captured without
+// the DPT-S1 present.
+type frameRecorder struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newFrameRecorder(path string) (*frameRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &frameRecorder{f: f}, nil
+}
+
+func (r *frameRecorder) Record(orientation byte, jpegBytes []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint64(header[0:8], uint64(time.Now().UnixNano()))
+	header[8] = orientation
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(jpegBytes)))
+
+	if _, err := r.f.Write(header); err != nil {
+		return err
+	}
+	_, err := r.f.Write(jpegBytes)
+	return err
+}
+
+func (r *frameRecorder) Close() error {
+	return r.f.Close()
+}
+
+// frameReplayer reads frames back out of a file written by frameRecorder,
+// reporting how long to wait before each one so playback matches the
+// recorded cadence. It loops back to the start of the file once exhausted.
+type frameReplayer struct {
+	f    *os.File
+	r    *bufio.Reader
+	last int64
+}
+
+func newFrameReplayer(path string) (*frameReplayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &frameReplayer{f: f, r: bufio.NewReader(f)}, nil
+}
+
+func (rp *frameReplayer) Next() (orientation byte, jpegBytes []byte, wait time.Duration, err error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err = io.ReadFull(rp.r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		if _, serr := rp.f.Seek(0, io.SeekStart); serr != nil {
+			return 0, nil, 0, serr
+		}
+		rp.r.Reset(rp.f)
+		rp.last = 0
+		if _, err = io.ReadFull(rp.r, header); err != nil {
+			return 0, nil, 0, err
+		}
+	} else if err != nil {
+		return 0, nil, 0, err
+	}
+
+	timestamp := int64(binary.BigEndian.Uint64(header[0:8]))
+	orientation = header[8]
+	length := binary.BigEndian.Uint32(header[9:13])
+
+	jpegBytes = make([]byte, length)
+	if _, err = io.ReadFull(rp.r, jpegBytes); err != nil {
+		return 0, nil, 0, err
+	}
+
+	if rp.last != 0 {
+		wait = time.Duration(timestamp - rp.last)
+	}
+	rp.last = timestamp
+	return orientation, jpegBytes, wait, nil
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 	"strings"
 	"context"
+
+	"github.com/grandcat/zeroconf"
 )
 
 func getDPTS1Addr() (string, error) {
@@ -89,3 +91,57 @@ func getDPTS1AddrPolling() (string, error) {
 		return c.RemoteAddr().String(), nil
 	}
 }
+
+// getDPTS1AddrMDNS browses for the DPT-S1 via mDNS/Bonjour, looking for a
+// service advertised as _dpts1._tcp.local. This works regardless of which
+// subnet the device has been bridged onto, unlike the multicast and polling
+// modes which assume 203.0.113.0/24.
+func getDPTS1AddrMDNS() (string, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return "", err
+	}
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := resolver.Browse(ctx, "_dpts1._tcp", "local.", entries); err != nil {
+		return "", err
+	}
+
+	entry, ok := <-entries
+	if !ok || entry == nil {
+		return "", errors.New("cannot find DPT-S1 via mDNS")
+	}
+	if len(entry.AddrIPv4) == 0 {
+		return "", errors.New("mDNS entry for DPT-S1 has no IPv4 address")
+	}
+	return fmt.Sprintf("%s:%d", entry.AddrIPv4[0].String(), entry.Port), nil
+}
+
+// discoverers maps the names accepted by -discover to the function that
+// implements that discovery mode.
+var discoverers = map[string]func() (string, error){
+	"mdns":      getDPTS1AddrMDNS,
+	"multicast": getDPTS1Addr,
+	"poll":      getDPTS1AddrPolling,
+}
+
+// discoverDPTS1Addr tries each discovery mode in order, falling back to the
+// next one if the current one fails to locate the device.
+func discoverDPTS1Addr(order []string) (string, error) {
+	var lastErr error
+	for _, mode := range order {
+		discover, ok := discoverers[mode]
+		if !ok {
+			return "", fmt.Errorf("unknown discovery mode %q", mode)
+		}
+		addr, err := discover()
+		if err == nil {
+			return addr, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", mode, err)
+	}
+	return "", fmt.Errorf("all discovery modes failed, last error: %w", lastErr)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// clientWriteTimeout bounds how long Broadcast will wait on a single slow
+// or stalled client before giving up on it, so one bad connection can't
+// back-pressure the primary device's refresh goroutine.
+const clientWriteTimeout = 3 * time.Second
+
+// frameServer re-serves the latest decoded frame from a device to any
+// number of subordinate viewers connected over addr, so that multiple
+// people can watch the same DPT-S1 without each of them opening a
+// competing TCP session to the device.
+type frameServer struct {
+	addr string
+
+	mu        sync.Mutex
+	clients   map[net.Conn]struct{}
+	lastFrame []byte // framed orientation+length+JPEG of the last Broadcast, nil until the first one
+}
+
+func newFrameServer(addr string) (*frameServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &frameServer{
+		addr:    addr,
+		clients: make(map[net.Conn]struct{}),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.clients[conn] = struct{}{}
+			frame := s.lastFrame
+			s.mu.Unlock()
+			// A client that connects while the page is static would
+			// otherwise never see a frame, since Broadcast only fires on
+			// change; hand it the last one we sent immediately.
+			if frame != nil {
+				go s.writeFrame(conn, frame)
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Broadcast re-encodes img as JPEG and fans it out to every connected
+// client, prefixed with an orientation byte and a 4-byte big-endian length
+// so that device.refreshFromPeer can parse it back out. Each client is
+// written to from its own goroutine under a deadline, so a stalled client
+// can't block the others or the caller (the primary device's refresh loop).
+func (s *frameServer) Broadcast(img *image.YCbCr, landscape bool) error {
+	w := &sliceWriter{}
+	if err := jpeg.Encode(w, img, nil); err != nil {
+		return err
+	}
+
+	orientation := byte('P')
+	if landscape {
+		orientation = 'L'
+	}
+	frame := make([]byte, 0, 5+len(w.buf))
+	frame = append(frame, orientation)
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(w.buf)))
+	frame = append(frame, length...)
+	frame = append(frame, w.buf...)
+
+	s.mu.Lock()
+	s.lastFrame = frame
+	clients := make([]net.Conn, 0, len(s.clients))
+	for conn := range s.clients {
+		clients = append(clients, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range clients {
+		go s.writeFrame(conn, frame)
+	}
+	return nil
+}
+
+func (s *frameServer) writeFrame(conn net.Conn, frame []byte) {
+	conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	if _, err := conn.Write(frame); err != nil {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+	}
+}
+
+// sliceWriter is a minimal io.Writer backed by an in-memory slice, used to
+// buffer a JPEG-encoded frame before fanning it out to every client.
+type sliceWriter struct {
+	buf []byte
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// refreshFromPeer reads one frame from a frameServer instead of talking to
+// the DPT-S1 directly, used when d.peerAddr is set via -connect.
+func (d *device) refreshFromPeer() (changed bool, err error) {
+	if d.peerConn == nil {
+		conn, err := net.Dial("tcp", d.peerAddr)
+		if err != nil {
+			return false, err
+		}
+		d.peerConn = conn
+		d.buffer = bufio.NewReader(conn)
+	}
+
+	orientation, err := d.buffer.ReadByte()
+	if err != nil {
+		d.peerConn.Close()
+		d.peerConn = nil
+		return false, err
+	}
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(d.buffer, lengthBytes); err != nil {
+		d.peerConn.Close()
+		d.peerConn = nil
+		return false, err
+	}
+	length := binary.BigEndian.Uint32(lengthBytes)
+
+	img, err := jpeg.Decode(io.LimitReader(d.buffer, int64(length)))
+	if err != nil {
+		return false, err
+	}
+
+	if orientation == 'L' {
+		if d.landscape.CompareAndSwap(false, true) && d.autoResize {
+			resizeWindowLandscape()
+		}
+	} else {
+		if d.landscape.CompareAndSwap(true, false) && d.autoResize {
+			resizeWindowPortrait()
+		}
+	}
+	d.display.Store(img.(*image.YCbCr))
+	return true, nil
+}
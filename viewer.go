@@ -0,0 +1,189 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Viewer owns one or more devices and lays them out side by side. With a
+// single device it behaves exactly like before; with two (via -compare) it
+// also renders a per-pixel Y-channel diff highlight in a third pane, so
+// firmware/page differences between the two units are easy to spot.
+type Viewer struct {
+	devices []*device
+
+	lastDiffA, lastDiffB *image.YCbCr
+	diffrgba             *image.RGBA
+	diffbuffer           *ebiten.Image
+}
+
+func NewViewer(devices []*device) *Viewer {
+	return &Viewer{devices: devices}
+}
+
+// StartRefreshLoop spawns one refresh goroutine per device. Devices fetched
+// directly from a DPT-S1 poll adaptively: the interval doubles (capped at
+// maxInterval) whenever a frame comes back unchanged and snaps back to
+// interval as soon as the page changes. Frames from the first device are
+// additionally broadcast to server, if one was started with -serve.
+func (v *Viewer) StartRefreshLoop(interval, maxInterval time.Duration, server *frameServer) {
+	for i, d := range v.devices {
+		d := d
+		primary := i == 0
+		broadcast := func(changed bool) {
+			if changed && primary && server != nil {
+				server.Broadcast(d.display.Load(), d.landscape.Load())
+			}
+		}
+
+		switch {
+		case d.replayer != nil:
+			// refreshFromReplay paces itself against the recorded
+			// timestamps (or replayFixedInterval), so it drives its own
+			// loop rather than waiting on a fixed ticker. On error (e.g. a
+			// truncated recording or a frame that fails to decode) back
+			// off by interval instead of hot-looping.
+			go func() {
+				for {
+					changed, err := d.Refresh()
+					if err != nil {
+						time.Sleep(interval)
+						continue
+					}
+					broadcast(changed)
+				}
+			}()
+		case d.peerAddr != "":
+			go func() {
+				t := time.NewTicker(interval)
+				for {
+					<-t.C
+					changed, err := d.Refresh()
+					if err == nil {
+						broadcast(changed)
+					}
+				}
+			}()
+		default:
+			d.minInterval = interval
+			d.maxInterval = maxInterval
+			d.currentInterval = interval
+			go func() {
+				for {
+					changed, err := d.Refresh()
+					if err == nil {
+						broadcast(changed)
+						if changed {
+							d.currentInterval = d.minInterval
+						} else {
+							d.currentInterval *= 2
+							if d.currentInterval > d.maxInterval {
+								d.currentInterval = d.maxInterval
+							}
+						}
+					}
+					time.Sleep(d.currentInterval)
+				}
+			}()
+		}
+	}
+}
+
+func (v *Viewer) Update() error {
+	for _, d := range v.devices {
+		if err := d.Update(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Viewer) Draw(screen *ebiten.Image) {
+	if len(v.devices) == 1 {
+		v.devices[0].Draw(screen)
+		return
+	}
+
+	x := 0
+	for _, d := range v.devices {
+		fb := d.prepareFramebuffer()
+		if fb == nil {
+			continue
+		}
+		opts := &ebiten.DrawImageOptions{}
+		if d.landscape.Load() {
+			opts.GeoM = ROTATE.GeoM
+		}
+		opts.GeoM.Translate(float64(x), 0)
+		screen.DrawImage(fb, opts)
+		w, _ := d.Layout(0, 0)
+		x += w
+	}
+
+	v.updateDiff()
+	if v.diffbuffer != nil {
+		opts := &ebiten.DrawImageOptions{}
+		opts.GeoM.Translate(float64(x), 0)
+		screen.DrawImage(v.diffbuffer, opts)
+	}
+}
+
+// updateDiff recomputes the Y-channel diff highlight between the first two
+// devices, skipping the work if neither frame has changed since last time or
+// the two frames are different sizes.
+func (v *Viewer) updateDiff() {
+	if len(v.devices) < 2 {
+		return
+	}
+	a := v.devices[0].display.Load()
+	b := v.devices[1].display.Load()
+	if a == nil || b == nil {
+		return
+	}
+	if a == v.lastDiffA && b == v.lastDiffB {
+		return
+	}
+	v.lastDiffA, v.lastDiffB = a, b
+
+	if a.Bounds() != b.Bounds() {
+		return
+	}
+	bounds := a.Bounds()
+	if v.diffrgba == nil || v.diffrgba.Bounds() != bounds {
+		v.diffrgba = image.NewRGBA(bounds)
+		v.diffbuffer = ebiten.NewImage(bounds.Dx(), bounds.Dy())
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			diff := int(a.Y[a.YOffset(x, y)]) - int(b.Y[b.YOffset(x, y)])
+			if diff < 0 {
+				diff = -diff
+			}
+			v.diffrgba.SetRGBA(x, y, color.RGBA{uint8(diff), 0, 0, 255})
+		}
+	}
+	v.diffbuffer.WritePixels(v.diffrgba.Pix)
+}
+
+func (v *Viewer) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+	if len(v.devices) == 1 {
+		return v.devices[0].Layout(outsideWidth, outsideHeight)
+	}
+	w, h := 0, 0
+	for _, d := range v.devices {
+		dw, dh := d.Layout(outsideWidth, outsideHeight)
+		w += dw
+		if dh > h {
+			h = dh
+		}
+	}
+	if len(v.devices) == 2 {
+		// diff pane is the same width as the first device
+		dw, _ := v.devices[0].Layout(outsideWidth, outsideHeight)
+		w += dw
+	}
+	return w, h
+}